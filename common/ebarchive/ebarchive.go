@@ -0,0 +1,447 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+// Package ebarchive implements a portable, verifiable archive format for
+// Entry Block chain history, modeled after Ethereum's "era" format. A chain's
+// EBlocks, headers, and referenced Entries are framed into an append-only
+// file that can be exported for cold storage or sharing, and later
+// re-imported and verified without access to the original database. Chains
+// longer than MaxEBlocksPerFile are sharded across several such files; see
+// Export and Continuation.
+package ebarchive
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/FactomProject/FactomCode/common"
+)
+
+// MaxEBlocksPerFile caps the number of EBlocks written to a single archive
+// file. Chains longer than this must be split across several files, the way
+// Ethereum's era format shards history.
+const MaxEBlocksPerFile = 8192
+
+// ArchiveVersion is the version written to every archive's leading version
+// record. It should be bumped if the framing or record layout changes.
+const ArchiveVersion = 1
+
+// Record types for the framed container format. Each record on disk is
+// [uint16 type][uint32 length][payload].
+const (
+	recTypeVersion uint16 = iota + 1
+	recTypeEBlockHeader
+	recTypeEBlockBody
+	recTypeEntry
+	recTypeAccumulator
+	recTypeIndex
+)
+
+const recordHeaderSize = 2 + 4 // uint16 type + uint32 length
+
+// maxRecordPayloadSize bounds the length a record header is trusted to
+// declare before its payload is read. Archives are cold-storage files that
+// may come from an untrusted source, so a corrupted or malicious length
+// field must not be able to trigger a multi-gigabyte allocation before the
+// short read that would eventually reveal it as bogus. 64 MiB comfortably
+// covers the largest legitimate record (an EBlockBody's worth of entry
+// hashes, bounded by maxEntryCount below).
+const maxRecordPayloadSize = 64 * 1024 * 1024
+
+// maxEntryCount bounds the EntryCount an Iterator will trust when
+// preallocating its Entries slice. Archives are cold-storage files that may
+// come from an untrusted source, so a corrupted EntryCount field must not be
+// able to trigger a multi-gigabyte allocation.
+const maxEntryCount = 1 << 20
+
+// indexEntry records the file offset of an EBlock's header record, keyed by
+// its EBSequence, so importers can seek directly to it.
+type indexEntry struct {
+	sequence uint32
+	offset   int64
+}
+
+// countingWriter wraps an io.Writer and tracks the number of bytes written so
+// far, which Export uses to build the trailing index table.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// writeRecord frames and writes a single typed record.
+func writeRecord(w io.Writer, typ uint16, payload []byte) error {
+	header := make([]byte, recordHeaderSize)
+	binary.BigEndian.PutUint16(header[0:2], typ)
+	binary.BigEndian.PutUint32(header[2:6], uint32(len(payload)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// readRecord reads a single typed record from r.
+func readRecord(r io.Reader) (uint16, []byte, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	typ := binary.BigEndian.Uint16(header[0:2])
+	length := binary.BigEndian.Uint32(header[2:6])
+	if length > maxRecordPayloadSize {
+		return 0, nil, fmt.Errorf("ebarchive: record declares a %d byte payload, more than the %d byte sanity limit", length, maxRecordPayloadSize)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return typ, payload, nil
+}
+
+// Continuation carries the one EBlock that didn't fit in an archive file
+// written by Export, along with the chain-linkage state needed to keep
+// verifying PrevKeyMR/PrevFullHash across the file boundary. Passing it back
+// into Export as the seed for the next file resumes the chain exactly where
+// the previous file left off, so no EBlock is ever dropped.
+type Continuation struct {
+	eb           *common.EBlock
+	entries      []*common.Entry
+	prevKeyMR    *common.Hash
+	prevFullHash *common.Hash
+}
+
+// Export writes up to MaxEBlocksPerFile EBlocks of a chain to w as a single
+// archive file. iter is called repeatedly to pull the next EBlock (in
+// ascending EBSequence order) and the Entries it references; it should
+// return ok == false once the chain is exhausted. seed, if non-nil, is the
+// Continuation returned by a previous call to Export for this chain, and is
+// written as the file's first EBlock instead of calling iter.
+//
+// Export verifies that each EBlock's PrevKeyMR and PrevFullHash correctly
+// chain back to the previous one, including the EBlock carried over from
+// seed. If the chain has more EBlocks left once MaxEBlocksPerFile have been
+// written, Export finishes the file (accumulator, index, and trailer all
+// cover exactly the EBlocks written to it) and returns a non-nil
+// Continuation for the caller to open a new file and pass to the next
+// Export call; very long chains are exported this way across several files,
+// the way Ethereum's era format shards history.
+func Export(chainID *common.Hash, w io.Writer, iter func() (*common.EBlock, []*common.Entry, bool), seed *Continuation) (*Continuation, error) {
+	cw := &countingWriter{w: w}
+
+	if err := writeRecord(cw, recTypeVersion, []byte{ArchiveVersion}); err != nil {
+		return nil, err
+	}
+
+	var index []indexEntry
+	var keyMRs []*common.Hash
+	var prevKeyMR, prevFullHash *common.Hash
+	if seed != nil {
+		prevKeyMR, prevFullHash = seed.prevKeyMR, seed.prevFullHash
+	}
+	count := 0
+
+	pull := func() (*common.EBlock, []*common.Entry, bool) {
+		if seed != nil {
+			eb, entries := seed.eb, seed.entries
+			seed = nil
+			return eb, entries, true
+		}
+		return iter()
+	}
+
+	for {
+		eb, entries, ok := pull()
+		if !ok {
+			break
+		}
+		if prevKeyMR != nil {
+			if !bytes.Equal(eb.Header.PrevKeyMR.Bytes(), prevKeyMR.Bytes()) {
+				return nil, fmt.Errorf("ebarchive: chain %s EBlock %d PrevKeyMR does not match the previous EBlock's KeyMR", chainID, eb.Header.EBSequence)
+			}
+			if !bytes.Equal(eb.Header.PrevFullHash.Bytes(), prevFullHash.Bytes()) {
+				return nil, fmt.Errorf("ebarchive: chain %s EBlock %d PrevFullHash does not match the previous EBlock's Hash", chainID, eb.Header.EBSequence)
+			}
+		}
+
+		if count >= MaxEBlocksPerFile {
+			if err := writeTrailer(cw, keyMRs, index); err != nil {
+				return nil, err
+			}
+			return &Continuation{eb: eb, entries: entries, prevKeyMR: prevKeyMR, prevFullHash: prevFullHash}, nil
+		}
+
+		index = append(index, indexEntry{sequence: eb.Header.EBSequence, offset: cw.n})
+
+		headerBytes, err := eb.Header.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		if err := writeRecord(cw, recTypeEBlockHeader, headerBytes); err != nil {
+			return nil, err
+		}
+
+		bodyBytes, err := eb.Body.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		if err := writeRecord(cw, recTypeEBlockBody, bodyBytes); err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			entryBytes, err := entry.MarshalBinary()
+			if err != nil {
+				return nil, err
+			}
+			if err := writeRecord(cw, recTypeEntry, entryBytes); err != nil {
+				return nil, err
+			}
+		}
+
+		keyMR := eb.KeyMR()
+		keyMRs = append(keyMRs, keyMR)
+		prevKeyMR = keyMR
+		prevFullHash = eb.Hash()
+		count++
+	}
+
+	if err := writeTrailer(cw, keyMRs, index); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// writeTrailer writes the accumulator record, the index record, and the
+// 8 byte offset trailer that close out an archive file, covering exactly
+// the EBlocks summarized by keyMRs and index.
+func writeTrailer(cw *countingWriter, keyMRs []*common.Hash, index []indexEntry) error {
+	accumulator := accumulatorOf(keyMRs)
+	if err := writeRecord(cw, recTypeAccumulator, accumulator.Bytes()); err != nil {
+		return err
+	}
+
+	indexOffset := cw.n
+	indexPayload := new(bytes.Buffer)
+	binary.Write(indexPayload, binary.BigEndian, uint32(len(index)))
+	for _, e := range index {
+		binary.Write(indexPayload, binary.BigEndian, e.sequence)
+		binary.Write(indexPayload, binary.BigEndian, uint64(e.offset))
+	}
+	if err := writeRecord(cw, recTypeIndex, indexPayload.Bytes()); err != nil {
+		return err
+	}
+
+	trailer := make([]byte, 8)
+	binary.BigEndian.PutUint64(trailer, uint64(indexOffset))
+	_, err := cw.Write(trailer)
+	return err
+}
+
+// accumulatorOf computes the Merkle root over a list of EBlock KeyMRs.
+func accumulatorOf(keyMRs []*common.Hash) *common.Hash {
+	if len(keyMRs) == 0 {
+		return common.NewHash()
+	}
+	mrs := common.BuildMerkleTreeStore(keyMRs)
+	return mrs[len(mrs)-1]
+}
+
+// Iterator streams EBlocks and their Entries out of an archive file in
+// sequence, verifying the chain linkage and, once exhausted, the trailing
+// accumulator.
+type Iterator struct {
+	r         io.Reader
+	n         uint32
+	prevKeyMR *common.Hash
+	prevHash  *common.Hash
+	keyMRs    []*common.Hash
+	err       error
+	done      bool
+}
+
+// NewIterator returns an Iterator over r, an archive as written by Export. It
+// reads and validates the leading version record before returning.
+func NewIterator(r io.Reader) (*Iterator, error) {
+	typ, payload, err := readRecord(r)
+	if err != nil {
+		return nil, err
+	}
+	if typ != recTypeVersion || len(payload) != 1 {
+		return nil, errors.New("ebarchive: missing or malformed version record")
+	}
+	if payload[0] != ArchiveVersion {
+		return nil, fmt.Errorf("ebarchive: unsupported archive version %d", payload[0])
+	}
+	return &Iterator{r: r}, nil
+}
+
+// Next returns the next EBlock and its Entries. It returns ok == false once
+// the accumulator record is reached or an error occurs; callers should then
+// check Err.
+func (it *Iterator) Next() (eb *common.EBlock, entries []*common.Entry, ok bool) {
+	if it.done {
+		return nil, nil, false
+	}
+
+	typ, payload, err := readRecord(it.r)
+	if err != nil {
+		it.err = err
+		it.done = true
+		return nil, nil, false
+	}
+
+	if typ == recTypeAccumulator {
+		it.done = true
+		want := accumulatorOf(it.keyMRs)
+		if !bytes.Equal(payload, want.Bytes()) {
+			it.err = errors.New("ebarchive: accumulator does not match the exported EBlocks")
+		}
+		return nil, nil, false
+	}
+
+	if typ != recTypeEBlockHeader {
+		it.err = fmt.Errorf("ebarchive: expected eblock-header record, got type %d", typ)
+		it.done = true
+		return nil, nil, false
+	}
+
+	header := common.NewEBlockHeader()
+	if err := header.UnmarshalBinary(payload); err != nil {
+		it.err = err
+		it.done = true
+		return nil, nil, false
+	}
+
+	btyp, bpayload, err := readRecord(it.r)
+	if err != nil {
+		it.err = err
+		it.done = true
+		return nil, nil, false
+	}
+	if btyp != recTypeEBlockBody {
+		it.err = fmt.Errorf("ebarchive: expected eblock-body record, got type %d", btyp)
+		it.done = true
+		return nil, nil, false
+	}
+	body := common.NewEBlockBody()
+	if err := body.UnmarshalBinary(bpayload); err != nil {
+		it.err = err
+		it.done = true
+		return nil, nil, false
+	}
+
+	if header.EntryCount > maxEntryCount {
+		it.err = fmt.Errorf("ebarchive: EBlock %d declares %d entries, more than the %d sanity limit", header.EBSequence, header.EntryCount, maxEntryCount)
+		it.done = true
+		return nil, nil, false
+	}
+
+	entries = make([]*common.Entry, 0, header.EntryCount)
+	for i := uint32(0); i < header.EntryCount; i++ {
+		etyp, epayload, err := readRecord(it.r)
+		if err != nil {
+			it.err = err
+			it.done = true
+			return nil, nil, false
+		}
+		if etyp != recTypeEntry {
+			it.err = fmt.Errorf("ebarchive: expected entry record, got type %d", etyp)
+			it.done = true
+			return nil, nil, false
+		}
+		entry := new(common.Entry)
+		if err := entry.UnmarshalBinary(epayload); err != nil {
+			it.err = err
+			it.done = true
+			return nil, nil, false
+		}
+		entries = append(entries, entry)
+	}
+
+	eb = &common.EBlock{Header: header, Body: body}
+
+	if it.prevKeyMR != nil {
+		if !bytes.Equal(eb.Header.PrevKeyMR.Bytes(), it.prevKeyMR.Bytes()) {
+			it.err = fmt.Errorf("ebarchive: EBlock %d PrevKeyMR does not chain back to the previous EBlock", eb.Header.EBSequence)
+			it.done = true
+			return nil, nil, false
+		}
+		if !bytes.Equal(eb.Header.PrevFullHash.Bytes(), it.prevHash.Bytes()) {
+			it.err = fmt.Errorf("ebarchive: EBlock %d PrevFullHash does not chain back to the previous EBlock", eb.Header.EBSequence)
+			it.done = true
+			return nil, nil, false
+		}
+	}
+
+	keyMR := eb.KeyMR()
+	it.keyMRs = append(it.keyMRs, keyMR)
+	it.prevKeyMR = keyMR
+	it.prevHash = eb.Hash()
+	it.n++
+
+	return eb, entries, true
+}
+
+// Number returns the count of EBlocks successfully returned by Next so far.
+func (it *Iterator) Number() uint32 {
+	return it.n
+}
+
+// Err returns the first error encountered by Next, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// ReadIndex reads the EBSequence-to-offset index table appended to the end of
+// an archive by Export, so a caller can seek directly to a given EBlock's
+// header record without scanning the file from the start.
+func ReadIndex(r io.ReadSeeker) (map[uint32]int64, error) {
+	if _, err := r.Seek(-8, io.SeekEnd); err != nil {
+		return nil, err
+	}
+	trailer := make([]byte, 8)
+	if _, err := io.ReadFull(r, trailer); err != nil {
+		return nil, err
+	}
+	indexOffset := int64(binary.BigEndian.Uint64(trailer))
+
+	if _, err := r.Seek(indexOffset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	typ, payload, err := readRecord(r)
+	if err != nil {
+		return nil, err
+	}
+	if typ != recTypeIndex {
+		return nil, errors.New("ebarchive: expected index record at trailer offset")
+	}
+
+	buf := bytes.NewReader(payload)
+	var count uint32
+	if err := binary.Read(buf, binary.BigEndian, &count); err != nil {
+		return nil, err
+	}
+	index := make(map[uint32]int64, count)
+	for i := uint32(0); i < count; i++ {
+		var sequence uint32
+		var offset uint64
+		if err := binary.Read(buf, binary.BigEndian, &sequence); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(buf, binary.BigEndian, &offset); err != nil {
+			return nil, err
+		}
+		index[sequence] = int64(offset)
+	}
+	return index, nil
+}
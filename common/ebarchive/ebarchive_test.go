@@ -0,0 +1,237 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package ebarchive
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/FactomProject/FactomCode/common"
+)
+
+// buildChain returns n EBlocks chained via PrevKeyMR/PrevFullHash, each
+// carrying one Entry, along with their chainID and each EBlock's Entries.
+// iterOver turns the result into an iterator suitable for Export.
+func buildChain(n int) (*common.Hash, []*common.EBlock, [][]*common.Entry) {
+	chainID := common.NewHash()
+	chainID.SetBytes(bytes.Repeat([]byte{0x01}, 32))
+
+	var ebs []*common.EBlock
+	var allEntries [][]*common.Entry
+	var prev *common.EBlock
+	for i := 0; i < n; i++ {
+		e := common.NewEBlock()
+		e.Header.ChainID = chainID
+		e.Header.EBSequence = uint32(i)
+		if prev != nil {
+			e.Header.PrevKeyMR = prev.KeyMR()
+			e.Header.PrevFullHash = prev.Hash()
+		}
+		entry := common.DeterministicEntry(i)
+		e.Body.EBEntries = append(e.Body.EBEntries, entry.Hash())
+		e.BuildHeader()
+
+		ebs = append(ebs, e)
+		allEntries = append(allEntries, []*common.Entry{entry})
+		prev = e
+	}
+	return chainID, ebs, allEntries
+}
+
+// iterOver returns an Export-compatible iterator over ebs/entries.
+func iterOver(ebs []*common.EBlock, entries [][]*common.Entry) func() (*common.EBlock, []*common.Entry, bool) {
+	i := 0
+	return func() (*common.EBlock, []*common.Entry, bool) {
+		if i >= len(ebs) {
+			return nil, nil, false
+		}
+		eb, es := ebs[i], entries[i]
+		i++
+		return eb, es, true
+	}
+}
+
+func TestExportIteratorRoundTrip(t *testing.T) {
+	chainID, ebs, entries := buildChain(3)
+
+	buf := new(bytes.Buffer)
+	cont, err := Export(chainID, buf, iterOver(ebs, entries), nil)
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	if cont != nil {
+		t.Fatalf("Export returned a Continuation for a chain under MaxEBlocksPerFile")
+	}
+
+	it, err := NewIterator(buf)
+	if err != nil {
+		t.Fatalf("NewIterator: %v", err)
+	}
+
+	var got []*common.EBlock
+	for {
+		eb, gotEntries, ok := it.Next()
+		if !ok {
+			break
+		}
+		if len(gotEntries) != 1 || !bytes.Equal(gotEntries[0].Hash().Bytes(), entries[len(got)][0].Hash().Bytes()) {
+			t.Fatalf("EBlock %d: entries did not round trip", eb.Header.EBSequence)
+		}
+		got = append(got, eb)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Iterator.Err: %v", err)
+	}
+	if len(got) != len(ebs) {
+		t.Fatalf("got %d EBlocks, want %d", len(got), len(ebs))
+	}
+	for i, eb := range got {
+		if !bytes.Equal(eb.KeyMR().Bytes(), ebs[i].KeyMR().Bytes()) {
+			t.Fatalf("EBlock %d KeyMR mismatch after round trip", i)
+		}
+	}
+}
+
+func TestExportRejectsBrokenChainLink(t *testing.T) {
+	chainID, ebs, entries := buildChain(2)
+	ebs[1].Header.PrevKeyMR = common.NewHash() // break the link
+
+	buf := new(bytes.Buffer)
+	_, err := Export(chainID, buf, iterOver(ebs, entries), nil)
+	if err == nil {
+		t.Fatalf("Export succeeded despite a broken PrevKeyMR link")
+	}
+}
+
+func TestExportSplitsAcrossFiles(t *testing.T) {
+	chainID, ebs, entries := buildChain(MaxEBlocksPerFile + 2)
+
+	file1 := new(bytes.Buffer)
+	cont, err := Export(chainID, file1, iterOver(ebs, entries), nil)
+	if err != nil {
+		t.Fatalf("Export (file 1): %v", err)
+	}
+	if cont == nil {
+		t.Fatalf("Export did not return a Continuation for a chain over MaxEBlocksPerFile")
+	}
+
+	it1, err := NewIterator(file1)
+	if err != nil {
+		t.Fatalf("NewIterator (file 1): %v", err)
+	}
+	n1 := 0
+	for {
+		if _, _, ok := it1.Next(); !ok {
+			break
+		}
+		n1++
+	}
+	if err := it1.Err(); err != nil {
+		t.Fatalf("file 1 Iterator.Err: %v", err)
+	}
+	if n1 != MaxEBlocksPerFile {
+		t.Fatalf("file 1 has %d EBlocks, want %d", n1, MaxEBlocksPerFile)
+	}
+
+	// The rest of the chain, starting from the block Export held back,
+	// must still be reachable via a second file seeded with cont.
+	remaining := iterOver(ebs[MaxEBlocksPerFile+1:], entries[MaxEBlocksPerFile+1:])
+
+	file2 := new(bytes.Buffer)
+	cont2, err := Export(chainID, file2, remaining, cont)
+	if err != nil {
+		t.Fatalf("Export (file 2): %v", err)
+	}
+	if cont2 != nil {
+		t.Fatalf("Export returned an unexpected second Continuation")
+	}
+
+	it2, err := NewIterator(file2)
+	if err != nil {
+		t.Fatalf("NewIterator (file 2): %v", err)
+	}
+	n2 := 0
+	for {
+		if _, _, ok := it2.Next(); !ok {
+			break
+		}
+		n2++
+	}
+	if err := it2.Err(); err != nil {
+		t.Fatalf("file 2 Iterator.Err: %v", err)
+	}
+	if n2 != len(ebs)-MaxEBlocksPerFile {
+		t.Fatalf("file 2 has %d EBlocks, want %d", n2, len(ebs)-MaxEBlocksPerFile)
+	}
+}
+
+func TestIteratorRejectsHugeEntryCount(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := writeRecord(buf, recTypeVersion, []byte{ArchiveVersion}); err != nil {
+		t.Fatalf("writeRecord version: %v", err)
+	}
+
+	header := common.NewEBlockHeader()
+	header.EntryCount = maxEntryCount + 1
+	headerBytes, err := header.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary header: %v", err)
+	}
+	if err := writeRecord(buf, recTypeEBlockHeader, headerBytes); err != nil {
+		t.Fatalf("writeRecord header: %v", err)
+	}
+	// An empty but well-formed body record, so Next gets as far as the
+	// EntryCount check instead of failing on a missing body.
+	if err := writeRecord(buf, recTypeEBlockBody, nil); err != nil {
+		t.Fatalf("writeRecord body: %v", err)
+	}
+
+	it, err := NewIterator(buf)
+	if err != nil {
+		t.Fatalf("NewIterator: %v", err)
+	}
+	if _, _, ok := it.Next(); ok {
+		t.Fatalf("Next succeeded despite an EntryCount over the sanity limit")
+	}
+	if it.Err() == nil {
+		t.Fatalf("expected an error for an EntryCount over the sanity limit")
+	}
+}
+
+func TestReadRecordRejectsHugeLength(t *testing.T) {
+	// A record header claiming a huge payload with nothing behind it must
+	// be rejected before readRecord tries to allocate that much memory.
+	header := make([]byte, recordHeaderSize)
+	binary.BigEndian.PutUint16(header[0:2], recTypeEBlockHeader)
+	binary.BigEndian.PutUint32(header[2:6], 0xFFFFFFF0)
+
+	if _, _, err := readRecord(bytes.NewReader(header)); err == nil {
+		t.Fatalf("readRecord succeeded despite a record length over the sanity limit")
+	}
+}
+
+func TestIteratorRejectsHugeLength(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := writeRecord(buf, recTypeVersion, []byte{ArchiveVersion}); err != nil {
+		t.Fatalf("writeRecord version: %v", err)
+	}
+
+	badHeader := make([]byte, recordHeaderSize)
+	binary.BigEndian.PutUint16(badHeader[0:2], recTypeEBlockHeader)
+	binary.BigEndian.PutUint32(badHeader[2:6], 0xFFFFFFF0)
+	buf.Write(badHeader)
+
+	it, err := NewIterator(buf)
+	if err != nil {
+		t.Fatalf("NewIterator: %v", err)
+	}
+	if _, _, ok := it.Next(); ok {
+		t.Fatalf("Next succeeded despite a record length over the sanity limit")
+	}
+	if it.Err() == nil {
+		t.Fatalf("expected an error for a record length over the sanity limit")
+	}
+}
@@ -7,7 +7,15 @@ package common
 import (
 	"bytes"
 	"encoding/binary"
-	"io"
+	"fmt"
+
+	"github.com/FactomProject/FactomCode/common/interfaces"
+	"github.com/FactomProject/FactomCode/common/wal"
+)
+
+var (
+	_ interfaces.IEntryBlock       = (*EBlock)(nil)
+	_ interfaces.IEntryBlockHeader = (*EBlockHeader)(nil)
 )
 
 const (
@@ -20,6 +28,11 @@ const (
 type EBlock struct {
 	Header *EBlockHeader
 	Body   *EBlockBody
+
+	// Wal, if set, receives a record on every AddEBEntry and
+	// AddEndOfMinuteMarker call so that a restart mid-minute can
+	// reconstruct this EBlock's in-progress body. See wal.Recover.
+	Wal *wal.WAL
 }
 
 // MakeEBlock creates a new Entry Block belonging to the provieded Entry Chain.
@@ -46,22 +59,41 @@ func NewEBlock() *EBlock {
 	return e
 }
 
+// SetWal attaches w to the Entry Block and writes an open-block record to
+// it, so that a restart mid-minute can reconstruct this Entry Block's body
+// by replaying the entries and minute markers appended after this call.
+func (e *EBlock) SetWal(w *wal.WAL) error {
+	if err := w.LogOpenBlock(e.Header.ChainID.Bytes(), e.Header.EBSequence, e.Header.DBHeight, e.Header.PrevKeyMR.Bytes(), e.Header.PrevFullHash.Bytes()); err != nil {
+		return err
+	}
+	e.Wal = w
+	return nil
+}
+
 // AddEBEntry creates a new Entry Block Entry from the provided Factom Entry
 // and adds it to the Entry Block Body.
 func (e *EBlock) AddEBEntry(entry *Entry) error {
-	e.Body.EBEntries = append(e.Body.EBEntries, entry.Hash())
+	hash := entry.Hash()
+	e.Body.EBEntries = append(e.Body.EBEntries, hash)
+	if e.Wal != nil {
+		return e.Wal.LogAppendEntry(hash.Bytes())
+	}
 	return nil
 }
 
 // AddEndOfMinuteMarker adds the End of Minute to the Entry Block. The End of
 // Minut byte becomes the last byte in a 32 byte slice that is added to the
 // Entry Block Body as an Entry Block Entry.
-func (e *EBlock) AddEndOfMinuteMarker(m byte) {
+func (e *EBlock) AddEndOfMinuteMarker(m byte) error {
 	h := make([]byte, 32)
 	h[len(h)-1] = m
 	hash := NewHash()
 	hash.SetBytes(h)
 	e.Body.EBEntries = append(e.Body.EBEntries, hash)
+	if e.Wal != nil {
+		return e.Wal.LogMinuteMarker(m)
+	}
+	return nil
 }
 
 // BuildHeader updates the Entry Block Header to include information about the
@@ -73,6 +105,21 @@ func (e *EBlock) BuildHeader() error {
 	return nil
 }
 
+// Seal finalizes the Entry Block's header and, if a Wal is attached, writes
+// a seal record for it. Call Seal once the Entry Block has been persisted to
+// the durable store; the WAL segments covering it are then safe to
+// garbage-collect.
+func (e *EBlock) Seal() error {
+	if err := e.BuildHeader(); err != nil {
+		return err
+	}
+	if e.Wal == nil {
+		return nil
+	}
+	keyMR := e.KeyMR()
+	return e.Wal.LogSeal(e.Header.BodyMR.Bytes(), keyMR.Bytes())
+}
+
 // Hash returns the simple Sha256 hash of the serialized Entry Block. Hash is
 // used to provide the PrevFullHash to the next Entry Block in a Chain.
 func (e *EBlock) Hash() *Hash {
@@ -88,14 +135,15 @@ func (e *EBlock) Hash() *Hash {
 // calculated by the func (e *EBlockBody) MR() which is called by the func
 // (e *EBlock) BuildHeader().
 func (e *EBlock) KeyMR() *Hash {
-	// Sha(Sha(header) + BodyMR)
 	e.BuildHeader()
-	header, err := e.Header.MarshalBinary()
-	if err != nil {
-		return NewHash()
-	}
-	h := Sha(header)
-	return Sha(append(h.Bytes(), e.Header.BodyMR.Bytes()...))
+	return e.Header.KeyMR()
+}
+
+// MarshalForSignature returns the serialized binary form of the Entry
+// Block's Header, excluding its mutable/derived fields. See
+// (e *EBlockHeader) MarshalForSignature.
+func (e *EBlock) MarshalForSignature() ([]byte, error) {
+	return e.Header.MarshalForSignature()
 }
 
 // MarshalBinary returns the serialized binary form of the Entry Block.
@@ -120,20 +168,71 @@ func (e *EBlock) MarshalBinary() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// GetHeader returns the Entry Block's header.
+func (e *EBlock) GetHeader() interfaces.IEntryBlockHeader {
+	return e.Header
+}
+
+// GetHash returns the Entry Block's Hash. See (e *EBlock) Hash.
+func (e *EBlock) GetHash() interfaces.IHash {
+	return e.Hash()
+}
+
+// GetKeyMR returns the Entry Block's KeyMR. See (e *EBlock) KeyMR.
+func (e *EBlock) GetKeyMR() interfaces.IHash {
+	return e.KeyMR()
+}
+
+// UnmarshalBinaryData populates the Entry Block object from the serialized
+// binary data, returning any unconsumed tail. Truncated or malformed input
+// is reported as an error rather than panicking.
+func (e *EBlock) UnmarshalBinaryData(data []byte) (newData []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("EBlock.UnmarshalBinaryData failed: %v", r)
+		}
+	}()
+
+	rest, err := e.Header.UnmarshalBinaryData(data)
+	if err != nil {
+		return nil, err
+	}
+
+	bodySize := int(e.Header.EntryCount) * 32
+	if len(rest) < bodySize {
+		return nil, fmt.Errorf("EBlock.UnmarshalBinaryData: body too short: need %d bytes, have %d", bodySize, len(rest))
+	}
+
+	if _, err := e.Body.UnmarshalBinaryData(rest[:bodySize]); err != nil {
+		return nil, err
+	}
+
+	return rest[bodySize:], nil
+}
+
 // UnmarshalBinary populates the Entry Block object from the serialized binary
 // data.
 func (e *EBlock) UnmarshalBinary(data []byte) error {
-	buf := bytes.NewBuffer(data)
-	
-	if err := e.Header.UnmarshalBinary(buf.Next(EBHeaderSize)); err != nil {
-		return err
-	}
-	
-	if err := e.Body.UnmarshalBinary(buf.Bytes()); err != nil {
-		return err
+	_, err := e.UnmarshalBinaryData(data)
+	return err
+}
+
+// UnmarshalEBlock decodes data as a single Entry Block.
+func UnmarshalEBlock(data []byte) (interfaces.IEntryBlock, error) {
+	e, _, err := UnmarshalEBlockData(data)
+	return e, err
+}
+
+// UnmarshalEBlockData decodes an Entry Block out of the front of data and
+// returns it along with any unconsumed tail, so that an Entry Block can be
+// decoded out of a larger message such as a peer-to-peer EntryBlockResponse.
+func UnmarshalEBlockData(data []byte) (interfaces.IEntryBlock, []byte, error) {
+	e := NewEBlock()
+	newData, err := e.UnmarshalBinaryData(data)
+	if err != nil {
+		return nil, nil, err
 	}
-	
-	return nil
+	return e, newData, nil
 }
 
 // EBlockBody is the series of Hashes that form the Entry Block Body.
@@ -167,25 +266,30 @@ func (e *EBlockBody) MR() *Hash {
 	return r
 }
 
-// UnmarshalBinary builds the Entry Block Body from the serialized binary.
-func (e *EBlockBody) UnmarshalBinary(data []byte) error {
-	buf := bytes.NewBuffer(data)
-	hash := make([]byte, 32)
-	
-	for {
-		if _, err := buf.Read(hash); err != nil {
-			if err == io.EOF {
-				break
-			}
-			return err
+// UnmarshalBinaryData builds the Entry Block Body from the serialized
+// binary, treating every 32 bytes of data as one Entry Block Entry hash and
+// returning any bytes left over once data is exhausted.
+func (e *EBlockBody) UnmarshalBinaryData(data []byte) (newData []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("EBlockBody.UnmarshalBinaryData failed: %v", r)
 		}
-		
+	}()
+
+	count := len(data) / 32
+	for i := 0; i < count; i++ {
 		h := NewHash()
-		h.SetBytes(hash)
+		h.SetBytes(data[i*32 : i*32+32])
 		e.EBEntries = append(e.EBEntries, h)
 	}
-	
-	return nil
+
+	return data[count*32:], nil
+}
+
+// UnmarshalBinary builds the Entry Block Body from the serialized binary.
+func (e *EBlockBody) UnmarshalBinary(data []byte) error {
+	_, err := e.UnmarshalBinaryData(data)
+	return err
 }
 
 // EBlockHeader holds relevent metadata about the Entry Block and the data
@@ -210,6 +314,28 @@ func NewEBlockHeader() *EBlockHeader {
 	return e
 }
 
+// MarshalForSignature returns the serialized binary form of everything in
+// the Entry Block Header except its mutable/derived fields, BodyMR and
+// EntryCount, so that an identity-level signature over a proposed block
+// remains stable as the body mutates during assembly.
+func (e *EBlockHeader) MarshalForSignature() ([]byte, error) {
+	buf := new(bytes.Buffer)
+
+	buf.Write(e.ChainID.Bytes())
+	buf.Write(e.PrevKeyMR.Bytes())
+	buf.Write(e.PrevFullHash.Bytes())
+
+	if err := binary.Write(buf, binary.BigEndian, e.EBSequence); err != nil {
+		return buf.Bytes(), err
+	}
+
+	if err := binary.Write(buf, binary.BigEndian, e.DBHeight); err != nil {
+		return buf.Bytes(), err
+	}
+
+	return buf.Bytes(), nil
+}
+
 // MarshalBinary returns a serialized binary Entry Block Header
 func (e *EBlockHeader) MarshalBinary() ([]byte, error) {
 	buf := new(bytes.Buffer)
@@ -241,46 +367,81 @@ func (e *EBlockHeader) MarshalBinary() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// UnmarshalBinaryData builds the Entry Block Header from the serialized
+// binary, returning any unconsumed tail. Truncated input is reported as an
+// error rather than panicking.
+func (e *EBlockHeader) UnmarshalBinaryData(data []byte) (newData []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("EBlockHeader.UnmarshalBinaryData failed: %v", r)
+		}
+	}()
+
+	e.ChainID.SetBytes(data[0:32])
+	e.BodyMR.SetBytes(data[32:64])
+	e.PrevKeyMR.SetBytes(data[64:96])
+	e.PrevFullHash.SetBytes(data[96:128])
+	e.EBSequence = binary.BigEndian.Uint32(data[128:132])
+	e.DBHeight = binary.BigEndian.Uint32(data[132:136])
+	e.EntryCount = binary.BigEndian.Uint32(data[136:140])
+
+	return data[EBHeaderSize:], nil
+}
+
 // UnmarshalBinary builds the Entry Block Header from the serialized binary.
 func (e *EBlockHeader) UnmarshalBinary(data []byte) error {
-	buf := bytes.NewBuffer(data)
-	hash := make([]byte, 32)
-	
-	if _, err := buf.Read(hash); err != nil {
-		return err
-	} else {
-		e.ChainID.SetBytes(hash)
-	}
+	_, err := e.UnmarshalBinaryData(data)
+	return err
+}
 
-	if _, err := buf.Read(hash); err != nil {
-		return err
-	} else {
-		e.BodyMR.SetBytes(hash)
-	}
+// GetChainID returns the Chain ID this Entry Block Header belongs to.
+func (e *EBlockHeader) GetChainID() interfaces.IHash {
+	return e.ChainID
+}
 
-	if _, err := buf.Read(hash); err != nil {
-		return err
-	} else {
-		e.PrevKeyMR.SetBytes(hash)
-	}
+// GetBodyMR returns the Merkle Root of the Entry Block Body.
+func (e *EBlockHeader) GetBodyMR() interfaces.IHash {
+	return e.BodyMR
+}
 
-	if _, err := buf.Read(hash); err != nil {
-		return err
-	} else {
-		e.PrevFullHash.SetBytes(hash)
-	}
-	
-	if err := binary.Read(buf, binary.BigEndian, &e.EBSequence); err != nil {
-		return err
-	}
-	
-	if err := binary.Read(buf, binary.BigEndian, &e.DBHeight); err != nil {
-		return err
-	}
-	
-	if err := binary.Read(buf, binary.BigEndian, &e.EntryCount); err != nil {
-		return err
+// GetPrevKeyMR returns the KeyMR of the previous Entry Block in the Chain.
+func (e *EBlockHeader) GetPrevKeyMR() interfaces.IHash {
+	return e.PrevKeyMR
+}
+
+// GetPrevFullHash returns the Hash of the previous Entry Block in the Chain.
+func (e *EBlockHeader) GetPrevFullHash() interfaces.IHash {
+	return e.PrevFullHash
+}
+
+// GetEBSequence returns the Entry Block's sequence number within its Chain.
+func (e *EBlockHeader) GetEBSequence() uint32 {
+	return e.EBSequence
+}
+
+// GetDBHeight returns the Directory Block height the Entry Block belongs to.
+func (e *EBlockHeader) GetDBHeight() uint32 {
+	return e.DBHeight
+}
+
+// GetEntryCount returns the number of Entry Block Entries in the Entry
+// Block Body.
+func (e *EBlockHeader) GetEntryCount() uint32 {
+	return e.EntryCount
+}
+
+// Hash returns the simple Sha256 hash of the serialized Entry Block Header.
+func (e *EBlockHeader) Hash() *Hash {
+	p, err := e.MarshalBinary()
+	if err != nil {
+		return NewHash()
 	}
+	return Sha(p)
+}
 
-	return nil
+// KeyMR returns Sha(Sha(header) + BodyMR). BodyMR must already be populated,
+// e.g. by (e *EBlock) BuildHeader(), for this to reflect the current Entry
+// Block Body.
+func (e *EBlockHeader) KeyMR() *Hash {
+	return Sha(append(e.Hash().Bytes(), e.BodyMR.Bytes()...))
 }
\ No newline at end of file
@@ -0,0 +1,150 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"encoding/hex"
+	"encoding/json"
+)
+
+// eBlockHeaderJSON is the on-the-wire JSON representation of an
+// EBlockHeader. Hashes are lowercase hex strings; KeyMR and Hash are
+// computed convenience fields so consumers don't have to recompute them.
+type eBlockHeaderJSON struct {
+	ChainID      string `json:"chainid"`
+	BodyMR       string `json:"bodymr"`
+	PrevKeyMR    string `json:"prevkeymr"`
+	PrevFullHash string `json:"prevfullhash"`
+	EBSequence   uint32 `json:"ebsequence"`
+	DBHeight     uint32 `json:"dbheight"`
+	EntryCount   uint32 `json:"entrycount"`
+	KeyMR        string `json:"key_mr"`
+	Hash         string `json:"hash"`
+}
+
+// MarshalJSON implements json.Marshaler for EBlockHeader.
+func (e *EBlockHeader) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&eBlockHeaderJSON{
+		ChainID:      hex.EncodeToString(e.ChainID.Bytes()),
+		BodyMR:       hex.EncodeToString(e.BodyMR.Bytes()),
+		PrevKeyMR:    hex.EncodeToString(e.PrevKeyMR.Bytes()),
+		PrevFullHash: hex.EncodeToString(e.PrevFullHash.Bytes()),
+		EBSequence:   e.EBSequence,
+		DBHeight:     e.DBHeight,
+		EntryCount:   e.EntryCount,
+		KeyMR:        hex.EncodeToString(e.KeyMR().Bytes()),
+		Hash:         hex.EncodeToString(e.Hash().Bytes()),
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler for EBlockHeader. The computed
+// key_mr and hash fields are ignored; they are derived, not stored.
+func (e *EBlockHeader) UnmarshalJSON(data []byte) error {
+	var j eBlockHeaderJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+
+	if e.ChainID == nil {
+		e.ChainID = NewHash()
+	}
+	if e.BodyMR == nil {
+		e.BodyMR = NewHash()
+	}
+	if e.PrevKeyMR == nil {
+		e.PrevKeyMR = NewHash()
+	}
+	if e.PrevFullHash == nil {
+		e.PrevFullHash = NewHash()
+	}
+
+	if err := setHashHex(e.ChainID, j.ChainID); err != nil {
+		return err
+	}
+	if err := setHashHex(e.BodyMR, j.BodyMR); err != nil {
+		return err
+	}
+	if err := setHashHex(e.PrevKeyMR, j.PrevKeyMR); err != nil {
+		return err
+	}
+	if err := setHashHex(e.PrevFullHash, j.PrevFullHash); err != nil {
+		return err
+	}
+
+	e.EBSequence = j.EBSequence
+	e.DBHeight = j.DBHeight
+	e.EntryCount = j.EntryCount
+
+	return nil
+}
+
+// eBlockBodyJSON is the on-the-wire JSON representation of an EBlockBody.
+type eBlockBodyJSON struct {
+	EBEntries []string `json:"ebentries"`
+}
+
+// MarshalJSON implements json.Marshaler for EBlockBody.
+func (e *EBlockBody) MarshalJSON() ([]byte, error) {
+	entries := make([]string, len(e.EBEntries))
+	for i, h := range e.EBEntries {
+		entries[i] = hex.EncodeToString(h.Bytes())
+	}
+	return json.Marshal(&eBlockBodyJSON{EBEntries: entries})
+}
+
+// UnmarshalJSON implements json.Unmarshaler for EBlockBody.
+func (e *EBlockBody) UnmarshalJSON(data []byte) error {
+	var j eBlockBodyJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+
+	entries := make([]*Hash, len(j.EBEntries))
+	for i, s := range j.EBEntries {
+		h := NewHash()
+		if err := setHashHex(h, s); err != nil {
+			return err
+		}
+		entries[i] = h
+	}
+	e.EBEntries = entries
+
+	return nil
+}
+
+// eBlockJSON is the on-the-wire JSON representation of an EBlock.
+type eBlockJSON struct {
+	Header *EBlockHeader `json:"header"`
+	Body   *EBlockBody   `json:"body"`
+}
+
+// MarshalJSON implements json.Marshaler for EBlock.
+func (e *EBlock) MarshalJSON() ([]byte, error) {
+	if err := e.BuildHeader(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(&eBlockJSON{Header: e.Header, Body: e.Body})
+}
+
+// UnmarshalJSON implements json.Unmarshaler for EBlock.
+func (e *EBlock) UnmarshalJSON(data []byte) error {
+	j := eBlockJSON{Header: e.Header, Body: e.Body}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	e.Header = j.Header
+	e.Body = j.Body
+	return nil
+}
+
+// setHashHex decodes s as hex and stores it in h.
+func setHashHex(h *Hash, s string) error {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	h.SetBytes(b)
+	return nil
+}
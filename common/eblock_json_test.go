@@ -0,0 +1,50 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestEBlockJSONRoundTrip(t *testing.T) {
+	e := NewEBlock()
+	e.Header.ChainID.SetBytes(bytes.Repeat([]byte{0x01}, 32))
+	e.Header.PrevKeyMR.SetBytes(bytes.Repeat([]byte{0x02}, 32))
+	e.Header.PrevFullHash.SetBytes(bytes.Repeat([]byte{0x03}, 32))
+	e.Header.EBSequence = 3
+	e.Header.DBHeight = 7
+
+	h1 := NewHash()
+	h1.SetBytes(bytes.Repeat([]byte{0x04}, 32))
+	h2 := NewHash()
+	h2.SetBytes(bytes.Repeat([]byte{0x05}, 32))
+	e.Body.EBEntries = append(e.Body.EBEntries, h1, h2)
+
+	wantBinary, err := e.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+
+	jsonBytes, err := json.Marshal(e)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+
+	got := NewEBlock()
+	if err := json.Unmarshal(jsonBytes, got); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+
+	gotBinary, err := got.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary after round trip: %v", err)
+	}
+
+	if !bytes.Equal(wantBinary, gotBinary) {
+		t.Fatalf("round trip mismatch:\nwant %x\ngot  %x", wantBinary, gotBinary)
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package common
+
+import "github.com/FactomProject/FactomCode/common/wal"
+
+// RecoverEBlocks reconstructs the EBlocks recorded in the write-ahead log
+// rooted at dir, including any unsealed EBlock left in progress by a crash
+// or restart mid-minute. It should be called on startup, before the
+// entry-block builder resumes.
+func RecoverEBlocks(dir string) ([]*EBlock, error) {
+	recovered, err := wal.Recover(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	eblocks := make([]*EBlock, 0, len(recovered))
+	for _, b := range recovered {
+		e := NewEBlock()
+		e.Header.ChainID.SetBytes(b.ChainID)
+		e.Header.EBSequence = b.EBSequence
+		e.Header.DBHeight = b.DBHeight
+		e.Header.PrevKeyMR.SetBytes(b.PrevKeyMR)
+		e.Header.PrevFullHash.SetBytes(b.PrevFullHash)
+
+		for _, entryHash := range b.Entries {
+			hash := NewHash()
+			hash.SetBytes(entryHash)
+			e.Body.EBEntries = append(e.Body.EBEntries, hash)
+		}
+
+		if b.Sealed {
+			e.Header.BodyMR.SetBytes(b.BodyMR)
+			e.Header.EntryCount = uint32(len(e.Body.EBEntries))
+		} else {
+			e.BuildHeader()
+		}
+
+		eblocks = append(eblocks, e)
+	}
+	return eblocks, nil
+}
@@ -0,0 +1,56 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEBlockHeaderUnmarshalBinaryDataTruncated(t *testing.T) {
+	for _, n := range []int{0, 1, EBHeaderSize - 1} {
+		data := bytes.Repeat([]byte{0xff}, n)
+		e := NewEBlockHeader()
+		if _, err := e.UnmarshalBinaryData(data); err == nil {
+			t.Fatalf("UnmarshalBinaryData with %d bytes did not return an error", n)
+		}
+	}
+}
+
+func TestEBlockUnmarshalBinaryDataTruncated(t *testing.T) {
+	// A valid-looking header claiming a body far longer than what follows
+	// it should be reported as an error, not panic or silently truncate.
+	e := NewEBlock()
+	e.Header.EntryCount = 100
+	headerBytes, err := e.Header.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary header: %v", err)
+	}
+
+	got := NewEBlock()
+	if _, err := got.UnmarshalBinaryData(headerBytes); err == nil {
+		t.Fatalf("UnmarshalBinaryData with a truncated body did not return an error")
+	}
+}
+
+func TestEBlockUnmarshalBinaryDataEmpty(t *testing.T) {
+	e := NewEBlock()
+	if _, err := e.UnmarshalBinaryData(nil); err == nil {
+		t.Fatalf("UnmarshalBinaryData(nil) did not return an error")
+	}
+}
+
+func TestEBlockBodyUnmarshalBinaryDataPartialHash(t *testing.T) {
+	// 10 bytes is less than one 32 byte Entry hash; UnmarshalBinaryData
+	// should return it as an unconsumed tail rather than panicking.
+	body := NewEBlockBody()
+	tail, err := body.UnmarshalBinaryData(bytes.Repeat([]byte{0x01}, 10))
+	if err != nil {
+		t.Fatalf("UnmarshalBinaryData: %v", err)
+	}
+	if len(tail) != 10 {
+		t.Fatalf("got %d leftover bytes, want 10", len(tail))
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestMarshalEntryListRoundTrip(t *testing.T) {
+	entries := []*Entry{DeterministicEntry(0), DeterministicEntry(1)}
+
+	data, err := MarshalEntryList(entries)
+	if err != nil {
+		t.Fatalf("MarshalEntryList: %v", err)
+	}
+
+	got, tail, err := UnmarshalEntryList(data)
+	if err != nil {
+		t.Fatalf("UnmarshalEntryList: %v", err)
+	}
+	if len(tail) != 0 {
+		t.Fatalf("UnmarshalEntryList left %d unconsumed bytes", len(tail))
+	}
+	if len(got) != len(entries) {
+		t.Fatalf("got %d entries, want %d", len(got), len(entries))
+	}
+	for i := range entries {
+		if !bytes.Equal(got[i].Hash().Bytes(), entries[i].Hash().Bytes()) {
+			t.Fatalf("entry %d hash mismatch after round trip", i)
+		}
+	}
+}
+
+func TestUnmarshalEntryListRejectsImpossibleCount(t *testing.T) {
+	// A count claiming far more entries than could possibly fit in the
+	// (empty) remaining data must be rejected before it is trusted to
+	// preallocate a slice.
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, 0xFFFFFFF0)
+
+	if _, _, err := UnmarshalEntryList(data); err == nil {
+		t.Fatal("UnmarshalEntryList succeeded despite an impossible entry count")
+	}
+}
+
+func TestDeterministicEntryIsStable(t *testing.T) {
+	a := DeterministicEntry(42)
+	b := DeterministicEntry(42)
+	if !bytes.Equal(a.Hash().Bytes(), b.Hash().Bytes()) {
+		t.Fatal("DeterministicEntry(42) produced different entries across calls")
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+// Package interfaces defines the shared interfaces implemented by Factom's
+// binary data types, so that packages which only need to read or decode a
+// type (peer messages, archive/recovery tooling, tests) do not have to
+// import common directly.
+package interfaces
+
+// IHash is the interface implemented by Factom's 32 byte hash type.
+type IHash interface {
+	Bytes() []byte
+	String() string
+	MarshalBinary() ([]byte, error)
+	UnmarshalBinary(data []byte) error
+}
+
+// IEntryBlockHeader is the interface implemented by an Entry Block's header.
+type IEntryBlockHeader interface {
+	MarshalBinary() ([]byte, error)
+	UnmarshalBinary(data []byte) error
+	UnmarshalBinaryData(data []byte) (newData []byte, err error)
+
+	GetChainID() IHash
+	GetBodyMR() IHash
+	GetPrevKeyMR() IHash
+	GetPrevFullHash() IHash
+	GetEBSequence() uint32
+	GetDBHeight() uint32
+	GetEntryCount() uint32
+}
+
+// IEntryBlock is the interface implemented by an Entry Block.
+type IEntryBlock interface {
+	MarshalBinary() ([]byte, error)
+	UnmarshalBinary(data []byte) error
+	UnmarshalBinaryData(data []byte) (newData []byte, err error)
+
+	GetHeader() IEntryBlockHeader
+	GetKeyMR() IHash
+	GetHash() IHash
+}
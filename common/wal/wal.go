@@ -0,0 +1,197 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+// Package wal implements a segmented, crash-safe write-ahead log for
+// in-progress Entry Block construction. The entry-block builder writes a
+// record on every append so that a restart mid-minute can reconstruct the
+// EBlock body in flight, mirroring the approach used by Prometheus's WAL.
+package wal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// DefaultSegmentSize is the size at which a WAL rotates to a new segment
+// file if the caller does not specify one.
+const DefaultSegmentSize = 16 * 1024 * 1024 // 16 MiB
+
+// Record types. Each record on disk is [uint8 type][uint32 len][payload][crc32c].
+const (
+	RecOpenBlock uint8 = iota + 1
+	RecAppendEntry
+	RecMinuteMarker
+	RecSeal
+)
+
+const recordHeaderSize = 1 + 4 // uint8 type + uint32 len
+const crcSize = 4
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// segmentName returns the file name for the segment at the given index.
+func segmentName(index int) string {
+	return fmt.Sprintf("%08d.wal", index)
+}
+
+// WAL is a segmented append-only write-ahead log.
+type WAL struct {
+	dir         string
+	segmentSize int64
+
+	cur      *os.File
+	curIndex int
+	curSize  int64
+}
+
+// Open opens (creating if necessary) a WAL rooted at dir, rotating to a new
+// segment once the active one reaches segmentSize bytes. A segmentSize of 0
+// uses DefaultSegmentSize.
+func Open(dir string, segmentSize int64) (*WAL, error) {
+	if segmentSize <= 0 {
+		segmentSize = DefaultSegmentSize
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	w := &WAL{dir: dir, segmentSize: segmentSize}
+
+	indexes, err := segmentIndexes(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	index := 0
+	if len(indexes) > 0 {
+		index = indexes[len(indexes)-1]
+	}
+	if err := w.openSegment(index); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// segmentIndexes returns the sorted segment indexes present in dir.
+func segmentIndexes(dir string) ([]int, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.wal"))
+	if err != nil {
+		return nil, err
+	}
+	indexes := make([]int, 0, len(matches))
+	for _, m := range matches {
+		var index int
+		if _, err := fmt.Sscanf(filepath.Base(m), "%08d.wal", &index); err != nil {
+			continue
+		}
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	return indexes, nil
+}
+
+// openSegment opens (creating if necessary) the segment file at index for
+// appending, and becomes the WAL's active segment.
+func (w *WAL) openSegment(index int) error {
+	path := filepath.Join(w.dir, segmentName(index))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.cur = f
+	w.curIndex = index
+	w.curSize = info.Size()
+	return nil
+}
+
+// writeRecord frames, checksums, and appends a single record, rotating to a
+// new segment first if it would not fit in the active one.
+func (w *WAL) writeRecord(typ uint8, payload []byte) error {
+	total := int64(recordHeaderSize + len(payload) + crcSize)
+	if w.curSize > 0 && w.curSize+total > w.segmentSize {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, recordHeaderSize+len(payload))
+	buf[0] = typ
+	binary.BigEndian.PutUint32(buf[1:5], uint32(len(payload)))
+	copy(buf[recordHeaderSize:], payload)
+
+	crc := crc32.Checksum(buf, castagnoli)
+	crcBuf := make([]byte, crcSize)
+	binary.BigEndian.PutUint32(crcBuf, crc)
+
+	if _, err := w.cur.Write(buf); err != nil {
+		return err
+	}
+	if _, err := w.cur.Write(crcBuf); err != nil {
+		return err
+	}
+	if err := w.cur.Sync(); err != nil {
+		return err
+	}
+	w.curSize += total
+	return nil
+}
+
+// rotate closes the active segment and opens the next one.
+func (w *WAL) rotate() error {
+	if err := w.cur.Close(); err != nil {
+		return err
+	}
+	return w.openSegment(w.curIndex + 1)
+}
+
+// LogOpenBlock records the start of a new in-progress EBlock. chainID,
+// prevKeyMR, and prevFullHash must each be a 32 byte Hash; prevKeyMR and
+// prevFullHash are the previous EBlock's KeyMR and Hash, so that a recovered
+// block's chain linkage can be restored exactly as it was before the crash.
+func (w *WAL) LogOpenBlock(chainID []byte, ebSequence, dbHeight uint32, prevKeyMR, prevFullHash []byte) error {
+	buf := new(bytes.Buffer)
+	buf.Write(chainID)
+	binary.Write(buf, binary.BigEndian, ebSequence)
+	binary.Write(buf, binary.BigEndian, dbHeight)
+	buf.Write(prevKeyMR)
+	buf.Write(prevFullHash)
+	return w.writeRecord(RecOpenBlock, buf.Bytes())
+}
+
+// LogAppendEntry records an Entry hash appended to the in-progress EBlock
+// body.
+func (w *WAL) LogAppendEntry(hash []byte) error {
+	return w.writeRecord(RecAppendEntry, hash)
+}
+
+// LogMinuteMarker records an End of Minute marker appended to the
+// in-progress EBlock body.
+func (w *WAL) LogMinuteMarker(m byte) error {
+	return w.writeRecord(RecMinuteMarker, []byte{m})
+}
+
+// LogSeal records that the in-progress EBlock has been built and persisted
+// to the durable store. Once a seal record for a block has been written, the
+// segments preceding it may be garbage-collected.
+func (w *WAL) LogSeal(bodyMR, keyMR []byte) error {
+	buf := new(bytes.Buffer)
+	buf.Write(bodyMR)
+	buf.Write(keyMR)
+	return w.writeRecord(RecSeal, buf.Bytes())
+}
+
+// Close closes the WAL's active segment.
+func (w *WAL) Close() error {
+	return w.cur.Close()
+}
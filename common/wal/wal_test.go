@@ -0,0 +1,211 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package wal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+)
+
+func repeat(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func TestRecoverRestoresOpenBlock(t *testing.T) {
+	dir := t.TempDir()
+
+	w, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	chainID := repeat(0x01)
+	prevKeyMR := repeat(0x02)
+	prevFullHash := repeat(0x03)
+	if err := w.LogOpenBlock(chainID, 3, 7, prevKeyMR, prevFullHash); err != nil {
+		t.Fatalf("LogOpenBlock: %v", err)
+	}
+	entryHash := repeat(0x04)
+	if err := w.LogAppendEntry(entryHash); err != nil {
+		t.Fatalf("LogAppendEntry: %v", err)
+	}
+	if err := w.LogMinuteMarker(1); err != nil {
+		t.Fatalf("LogMinuteMarker: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	blocks, err := Recover(dir)
+	if err != nil {
+		t.Fatalf("Recover: %v", err)
+	}
+	if len(blocks) != 1 {
+		t.Fatalf("got %d blocks, want 1", len(blocks))
+	}
+
+	b := blocks[0]
+	if !bytes.Equal(b.ChainID, chainID) {
+		t.Fatalf("ChainID = %x, want %x", b.ChainID, chainID)
+	}
+	if !bytes.Equal(b.PrevKeyMR, prevKeyMR) {
+		t.Fatalf("PrevKeyMR = %x, want %x", b.PrevKeyMR, prevKeyMR)
+	}
+	if !bytes.Equal(b.PrevFullHash, prevFullHash) {
+		t.Fatalf("PrevFullHash = %x, want %x", b.PrevFullHash, prevFullHash)
+	}
+	if len(b.Entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(b.Entries))
+	}
+	if b.Sealed {
+		t.Fatalf("block should not be sealed")
+	}
+}
+
+func TestRecoverSealedBlock(t *testing.T) {
+	dir := t.TempDir()
+
+	w, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := w.LogOpenBlock(repeat(0x01), 1, 1, repeat(0x00), repeat(0x00)); err != nil {
+		t.Fatalf("LogOpenBlock: %v", err)
+	}
+	bodyMR := repeat(0x05)
+	keyMR := repeat(0x06)
+	if err := w.LogSeal(bodyMR, keyMR); err != nil {
+		t.Fatalf("LogSeal: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	blocks, err := Recover(dir)
+	if err != nil {
+		t.Fatalf("Recover: %v", err)
+	}
+	if len(blocks) != 1 {
+		t.Fatalf("got %d blocks, want 1", len(blocks))
+	}
+	if !blocks[0].Sealed {
+		t.Fatalf("block should be sealed")
+	}
+	if !bytes.Equal(blocks[0].BodyMR, bodyMR) || !bytes.Equal(blocks[0].KeyMR, keyMR) {
+		t.Fatalf("BodyMR/KeyMR not restored correctly")
+	}
+}
+
+func TestRecoverRejectsHugeRecordLength(t *testing.T) {
+	dir := t.TempDir()
+
+	w, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := w.LogOpenBlock(repeat(0x01), 1, 1, repeat(0x00), repeat(0x00)); err != nil {
+		t.Fatalf("LogOpenBlock: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// Corrupt the open-block record's length field to claim a payload far
+	// larger than the segment actually holds.
+	path := dir + "/" + segmentName(0)
+	f, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	lengthField := make([]byte, 4)
+	binary.BigEndian.PutUint32(lengthField, 0xFFFFFFF0)
+	if _, err := f.WriteAt(lengthField, 1); err != nil {
+		t.Fatalf("WriteAt: %v", err)
+	}
+	f.Close()
+
+	blocks, err := Recover(dir)
+	if err != nil {
+		t.Fatalf("Recover: %v", err)
+	}
+	if len(blocks) != 0 {
+		t.Fatalf("got %d blocks, want 0 for a segment with a corrupted record length", len(blocks))
+	}
+}
+
+func TestRecoverStopsAtShortOpenBlockPayload(t *testing.T) {
+	dir := t.TempDir()
+
+	w, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	// Simulate a RecOpenBlock record in the old, pre-linkage format: a
+	// well-formed (valid CRC) record, just too short for this version of
+	// Recover to read PrevKeyMR/PrevFullHash out of.
+	old := append(append([]byte{}, repeat(0x01)...), []byte{0, 0, 0, 1, 0, 0, 0, 1}...)
+	if err := w.writeRecord(RecOpenBlock, old); err != nil {
+		t.Fatalf("writeRecord: %v", err)
+	}
+	if err := w.LogAppendEntry(repeat(0x02)); err != nil {
+		t.Fatalf("LogAppendEntry: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	blocks, err := Recover(dir)
+	if err != nil {
+		t.Fatalf("Recover: %v", err)
+	}
+	if len(blocks) != 0 {
+		t.Fatalf("got %d blocks, want 0 for an unusable open-block record", len(blocks))
+	}
+}
+
+func TestRecoverStopsAtTruncatedRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	w, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := w.LogOpenBlock(repeat(0x01), 1, 1, repeat(0x00), repeat(0x00)); err != nil {
+		t.Fatalf("LogOpenBlock: %v", err)
+	}
+	if err := w.LogAppendEntry(repeat(0x02)); err != nil {
+		t.Fatalf("LogAppendEntry: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	path := dir + "/" + segmentName(0)
+	f, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if err := f.Truncate(info.Size() - 3); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+	f.Close()
+
+	blocks, err := Recover(dir)
+	if err != nil {
+		t.Fatalf("Recover: %v", err)
+	}
+	if len(blocks) != 1 {
+		t.Fatalf("got %d blocks, want 1", len(blocks))
+	}
+	if len(blocks[0].Entries) != 0 {
+		t.Fatalf("truncated append-entry record should not have been replayed, got %d entries", len(blocks[0].Entries))
+	}
+}